@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -13,6 +17,7 @@ import (
 
 	"github.com/chzyer/readline"
 	"github.com/juju/errors"
+	"github.com/pingcap/tidb/config"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/store/tikv"
 	"github.com/pingcap/tidb/terror"
@@ -25,25 +30,114 @@ type KV struct {
 }
 
 func (kv KV) String() string {
+	if kv.V == nil {
+		// --keys-only scans never populate V.
+		return string(kv.K)
+	}
 	return fmt.Sprintf("%s => %s (%v)", kv.K, kv.V, kv.V)
 }
 
+// jsonKV is the wire shape for "-o json" output: keys and values are
+// base64-encoded so arbitrary binary content survives the pipeline.
+type jsonKV struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (kv KV) toJSON() jsonKV {
+	return jsonKV{
+		Key:   base64.StdEncoding.EncodeToString(kv.K),
+		Value: base64.StdEncoding.EncodeToString(kv.V),
+	}
+}
+
 var (
-	store  kv.Storage
-	pdAddr = flag.String("pd", "localhost:2379", "pd address:localhost:2379")
+	store        kv.Storage
+	pdAddr       = flag.String("pd", "localhost:2379", "pd address:localhost:2379")
+	execStr      = flag.String("e", "", "execute a semicolon-separated list of commands and exit")
+	outputFormat = flag.String("o", "plain", "output format: plain, json, hex")
+
+	caCert             = flag.String("cacert", "", "path to PEM CA certificate for TLS")
+	clientCert         = flag.String("cert", "", "path to PEM client certificate for TLS")
+	clientKey          = flag.String("key", "", "path to PEM client key for TLS")
+	tlsServerName      = flag.String("tls-server-name", "", "server name to verify in the PD/TiKV certificate (not supported by the vendored tikv driver)")
+	insecureSkipVerify = flag.Bool("insecure-skip-verify", false, "skip TLS certificate verification (not supported by the vendored tikv driver)")
+)
+
+// Isolation levels accepted by set_isolation.
+const (
+	isolationSI = "SI"
+	isolationRC = "RC"
 )
 
+// currentTx is the REPL-level transaction started by "begin". When nil, every
+// command opens and closes its own transaction as before.
+var (
+	currentTx   kv.Transaction
+	txIsolation = isolationSI
+)
+
+// validateTLSFlags ensures -cacert, -cert and -key are either all set
+// (mutual TLS) or all unset, so a partial cert triple fails fast rather than
+// silently connecting without TLS.
+func validateTLSFlags() error {
+	set := 0
+	for _, f := range []string{*caCert, *clientCert, *clientKey} {
+		if f != "" {
+			set++
+		}
+	}
+	if set != 0 && set != 3 {
+		return errors.New("-cacert, -cert and -key must all be set together for mutual TLS")
+	}
+	// The vendored tikv driver's cluster TLS support (config.Security) only
+	// takes a CA/cert/key triple; it has no hook for a server name override
+	// or for disabling certificate verification, so fail fast instead of
+	// silently accepting flags that would never reach the TLS handshake.
+	if *tlsServerName != "" {
+		return errors.New("-tls-server-name is not supported by the vendored tikv driver's cluster TLS config")
+	}
+	if *insecureSkipVerify {
+		return errors.New("-insecure-skip-verify is not supported by the vendored tikv driver's cluster TLS config")
+	}
+	return nil
+}
+
 // Init initializes information.
 func initStore() {
+	terror.MustNil(validateTLSFlags())
+
+	if *caCert != "" {
+		cfg := config.GetGlobalConfig()
+		cfg.Security.ClusterSSLCA = *caCert
+		cfg.Security.ClusterSSLCert = *clientCert
+		cfg.Security.ClusterSSLKey = *clientKey
+		config.StoreGlobalConfig(cfg)
+	}
+
 	driver := tikv.Driver{}
 	var err error
 	store, err = driver.Open(fmt.Sprintf("tikv://%s", *pdAddr))
 	terror.MustNil(err)
 }
 
+// beginTx returns the active REPL transaction if one was started with
+// "begin", otherwise it opens a fresh one. The bool result reports whether
+// the caller owns the transaction and must commit/close it itself.
+func beginTx() (kv.Transaction, bool, error) {
+	if currentTx != nil {
+		return currentTx, false, nil
+	}
+	tx, err := store.Begin()
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	return tx, true, nil
+}
+
 // key1 val1 key2 val2 ...
 func puts(args ...[]byte) error {
-	tx, err := store.Begin()
+	tx, owned, err := beginTx()
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -55,11 +149,84 @@ func puts(args ...[]byte) error {
 			return errors.Trace(err)
 		}
 	}
-	err = tx.Commit(goctx.Background())
+	if owned {
+		if err := tx.Commit(goctx.Background()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	return nil
+}
+
+func doBegin(args [][]byte) error {
+	if len(args) != 0 {
+		return errors.New("begin")
+	}
+	if currentTx != nil {
+		return errors.New("already in a transaction, commit or rollback first")
+	}
+
+	tx, err := store.Begin()
 	if err != nil {
 		return errors.Trace(err)
 	}
 
+	level := kv.SI
+	if txIsolation == isolationRC {
+		level = kv.RC
+	}
+	tx.SetOption(kv.IsolationLevel, level)
+
+	currentTx = tx
+	return nil
+}
+
+func doCommit(args [][]byte) error {
+	if len(args) != 0 {
+		return errors.New("commit")
+	}
+	if currentTx == nil {
+		return errors.New("not in a transaction")
+	}
+
+	tx := currentTx
+	currentTx = nil
+	if err := tx.Commit(goctx.Background()); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func doRollback(args [][]byte) error {
+	if len(args) != 0 {
+		return errors.New("rollback")
+	}
+	if currentTx == nil {
+		return errors.New("not in a transaction")
+	}
+
+	tx := currentTx
+	currentTx = nil
+	if err := tx.Rollback(); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func doSetIsolation(args [][]byte) error {
+	if len(args) != 1 {
+		return errors.New("set_isolation [SI|RC]")
+	}
+	if currentTx != nil {
+		return errors.New("cannot change isolation level inside a transaction")
+	}
+
+	switch level := strings.ToUpper(string(args[0])); level {
+	case isolationSI, isolationRC:
+		txIsolation = level
+	default:
+		return errors.Errorf("unknown isolation level %q, want SI or RC", level)
+	}
 	return nil
 }
 
@@ -81,10 +248,13 @@ func doGet(args [][]byte) (KV, error) {
 	if len(args) != 1 {
 		return KV{}, errors.New("get [key]")
 	}
-	tx, err := store.Begin()
+	tx, owned, err := beginTx()
 	if err != nil {
 		return KV{}, errors.Trace(err)
 	}
+	if owned {
+		defer tx.Rollback()
+	}
 	v, err := tx.Get(args[0])
 	if err != nil {
 		return KV{}, errors.Trace(err)
@@ -97,7 +267,7 @@ func doDel(args [][]byte) error {
 		return errors.New("del [key 1] ... [key N]")
 	}
 
-	tx, err := store.Begin()
+	tx, owned, err := beginTx()
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -109,43 +279,477 @@ func doDel(args [][]byte) error {
 			return errors.Trace(err)
 		}
 	}
-	err = tx.Commit(goctx.Background())
-	if err != nil {
-		return errors.Trace(err)
+	if owned {
+		if err := tx.Commit(goctx.Background()); err != nil {
+			return errors.Trace(err)
+		}
 	}
 	return nil
 }
 
+// scanOpts holds the flags shared by seek/rseek/scan: --keys-only skips
+// value materialization, --end bounds the scan by key instead of only by
+// count.
+type scanOpts struct {
+	keysOnly bool
+	end      []byte
+}
+
+// extractScanOpts pulls --keys-only and --end out of args, wherever they
+// appear, and returns the remaining positional arguments.
+func extractScanOpts(args [][]byte) ([][]byte, scanOpts, error) {
+	var opts scanOpts
+	var rest [][]byte
+	for i := 0; i < len(args); i++ {
+		switch string(args[i]) {
+		case "--keys-only":
+			opts.keysOnly = true
+		case "--end":
+			if i+1 >= len(args) {
+				return nil, opts, errors.New("--end requires a key")
+			}
+			i++
+			opts.end = args[i]
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return rest, opts, nil
+}
+
+// scanKV builds a result entry for a scan iterator, honoring --keys-only.
+func scanKV(it kv.Iterator, keysOnly bool) KV {
+	if keysOnly {
+		return KV{K: it.Key()[:]}
+	}
+	return KV{K: it.Key()[:], V: it.Value()[:]}
+}
+
 func doSeek(args [][]byte) ([]KV, error) {
-	if len(args) != 2 {
-		return nil, errors.New("seek [start key] [limit]")
+	rest, opts, err := extractScanOpts(args)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(rest) < 1 || len(rest) > 2 {
+		return nil, errors.New("seek [start key] [limit] [--end key] [--keys-only]")
 	}
 
-	tx, err := store.Begin()
+	tx, owned, err := beginTx()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if owned {
+		defer tx.Rollback()
+	}
+
+	it, err := tx.Seek(rest[0])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer it.Close()
+
+	cnt := -1
+	if len(rest) == 2 {
+		cnt, err = strconv.Atoi(string(rest[1]))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if cnt < 0 && opts.end == nil {
+		return nil, errors.New("seek [start key] [limit] [--end key] [--keys-only]: need [limit] or --end")
+	}
+
+	var ret []KV
+	for it.Valid() && cnt != 0 {
+		if opts.end != nil && bytes.Compare(it.Key(), opts.end) >= 0 {
+			break
+		}
+		ret = append(ret, scanKV(it, opts.keysOnly))
+		if cnt > 0 {
+			cnt--
+		}
+		if err := it.Next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return ret, nil
+}
+
+func doRSeek(args [][]byte) ([]KV, error) {
+	rest, opts, err := extractScanOpts(args)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if len(rest) < 1 || len(rest) > 2 {
+		return nil, errors.New("rseek [start key] [limit] [--end key] [--keys-only]")
+	}
 
-	keyPrefix := args[0]
-	it, err := tx.Seek(keyPrefix)
+	tx, owned, err := beginTx()
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if owned {
+		defer tx.Rollback()
+	}
 
-	cnt, err := strconv.Atoi(string(args[1]))
+	it, err := tx.IterReverse(rest[0])
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	defer it.Close()
+
+	cnt := -1
+	if len(rest) == 2 {
+		cnt, err = strconv.Atoi(string(rest[1]))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if cnt < 0 && opts.end == nil {
+		return nil, errors.New("rseek [start key] [limit] [--end key] [--keys-only]: need [limit] or --end")
+	}
+
 	var ret []KV
-	for it.Valid() && cnt > 0 {
-		ret = append(ret, KV{K: it.Key()[:], V: it.Value()[:]})
-		cnt--
-		it.Next()
+	for it.Valid() && cnt != 0 {
+		if opts.end != nil && bytes.Compare(it.Key(), opts.end) <= 0 {
+			break
+		}
+		ret = append(ret, scanKV(it, opts.keysOnly))
+		if cnt > 0 {
+			cnt--
+		}
+		if err := it.Next(); err != nil {
+			return nil, errors.Trace(err)
+		}
 	}
-	it.Close()
 	return ret, nil
 }
 
+func doScan(args [][]byte) ([]KV, error) {
+	rest, opts, err := extractScanOpts(args)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(rest) < 1 || len(rest) > 2 {
+		return nil, errors.New("scan [prefix] [limit] [--end key] [--keys-only]")
+	}
+
+	tx, owned, err := beginTx()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if owned {
+		defer tx.Rollback()
+	}
+
+	prefix := rest[0]
+	it, err := tx.Seek(prefix)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer it.Close()
+
+	cnt := -1
+	if len(rest) == 2 {
+		cnt, err = strconv.Atoi(string(rest[1]))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	var ret []KV
+	for it.Valid() && cnt != 0 && bytes.HasPrefix(it.Key(), prefix) {
+		if opts.end != nil && bytes.Compare(it.Key(), opts.end) >= 0 {
+			break
+		}
+		ret = append(ret, scanKV(it, opts.keysOnly))
+		if cnt > 0 {
+			cnt--
+		}
+		if err := it.Next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return ret, nil
+}
+
+// dumpOpts holds the flags shared by dump/restore.
+type dumpOpts struct {
+	batchSize int
+	jsonFmt   bool
+}
+
+// extractDumpOpts pulls --batch-size and --format out of args, wherever
+// they appear, and returns the remaining positional arguments.
+func extractDumpOpts(args [][]byte, defaultBatch int) ([][]byte, dumpOpts, error) {
+	opts := dumpOpts{batchSize: defaultBatch}
+	var rest [][]byte
+	for i := 0; i < len(args); i++ {
+		switch string(args[i]) {
+		case "--batch-size":
+			if i+1 >= len(args) {
+				return nil, opts, errors.New("--batch-size requires a number")
+			}
+			i++
+			n, err := strconv.Atoi(string(args[i]))
+			if err != nil || n <= 0 {
+				return nil, opts, errors.New("--batch-size requires a positive number")
+			}
+			opts.batchSize = n
+		case "--format":
+			if i+1 >= len(args) {
+				return nil, opts, errors.New("--format requires a value")
+			}
+			i++
+			switch string(args[i]) {
+			case "json":
+				opts.jsonFmt = true
+			case "binary":
+				opts.jsonFmt = false
+			default:
+				return nil, opts, errors.Errorf("unknown --format %q, want json or binary", args[i])
+			}
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return rest, opts, nil
+}
+
+// writeDumpRecord writes one length-prefixed (varint keylen, key, varint
+// vallen, val) record, the binary dump format.
+func writeDumpRecord(w *bufio.Writer, key, val []byte) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(key)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := w.Write(key); err != nil {
+		return errors.Trace(err)
+	}
+	n = binary.PutUvarint(buf[:], uint64(len(val)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := w.Write(val); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// writeDumpJSON writes one newline-delimited JSON record with base64 fields,
+// the --format json dump alternative.
+func writeDumpJSON(w *bufio.Writer, key, val []byte) error {
+	rec := KV{K: key, V: val}.toJSON()
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		return errors.Trace(err)
+	}
+	return w.WriteByte('\n')
+}
+
+// nextKey returns the lexicographically smallest key greater than k, used to
+// resume a dump from the last key of the previous batch.
+func nextKey(k []byte) []byte {
+	next := make([]byte, len(k)+1)
+	copy(next, k)
+	return next
+}
+
+func doDump(args [][]byte) error {
+	rest, opts, err := extractDumpOpts(args, 1000)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(rest) != 2 {
+		return errors.New("dump [prefix] [file] [--batch-size N] [--format json]")
+	}
+	prefix, path := rest[0], string(rest[1])
+
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	start := append([]byte{}, prefix...)
+	total := 0
+	for {
+		// Reopen a fresh snapshot for each batch so a large dump never holds
+		// one long-lived transaction.
+		tx, err := store.Begin()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		it, err := tx.Seek(start)
+		if err != nil {
+			tx.Rollback()
+			return errors.Trace(err)
+		}
+
+		n := 0
+		for ; n < opts.batchSize && it.Valid() && bytes.HasPrefix(it.Key(), prefix); n++ {
+			key, val := it.Key()[:], it.Value()[:]
+			if opts.jsonFmt {
+				err = writeDumpJSON(w, key, val)
+			} else {
+				err = writeDumpRecord(w, key, val)
+			}
+			if err != nil {
+				it.Close()
+				tx.Rollback()
+				return errors.Trace(err)
+			}
+			start = nextKey(key)
+			if err := it.Next(); err != nil {
+				it.Close()
+				tx.Rollback()
+				return errors.Trace(err)
+			}
+		}
+		more := it.Valid() && bytes.HasPrefix(it.Key(), prefix)
+		it.Close()
+		tx.Rollback()
+
+		total += n
+		fmt.Fprintf(os.Stderr, "dump: %d keys written\n", total)
+
+		if n == 0 || !more {
+			break
+		}
+	}
+
+	return errors.Trace(w.Flush())
+}
+
+// readDumpRecord reads one binary-format record written by writeDumpRecord.
+// It returns io.EOF once the file is exhausted.
+func readDumpRecord(r *bufio.Reader) ([]byte, []byte, error) {
+	klen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := make([]byte, klen)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	vlen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	val := make([]byte, vlen)
+	if _, err := io.ReadFull(r, val); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return key, val, nil
+}
+
+// readJSONRecord reads one newline-delimited JSON record written by
+// writeDumpJSON. It returns (nil, nil, nil) for a blank line and io.EOF once
+// the file is exhausted.
+func readJSONRecord(r *bufio.Reader) ([]byte, []byte, error) {
+	line, err := r.ReadBytes('\n')
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 {
+		return nil, nil, err
+	}
+
+	var rec jsonKV
+	if jerr := json.Unmarshal(trimmed, &rec); jerr != nil {
+		return nil, nil, errors.Trace(jerr)
+	}
+	key, kerr := base64.StdEncoding.DecodeString(rec.Key)
+	if kerr != nil {
+		return nil, nil, errors.Trace(kerr)
+	}
+	val, verr := base64.StdEncoding.DecodeString(rec.Value)
+	if verr != nil {
+		return nil, nil, errors.Trace(verr)
+	}
+	if err != nil && err != io.EOF {
+		return nil, nil, errors.Trace(err)
+	}
+	return key, val, nil
+}
+
+// restoreBatch commits a batch of key/value pairs via puts(), retrying a
+// few times on write conflicts with concurrent transactions.
+func restoreBatch(batch [][]byte) error {
+	const maxAttempts = 3
+	for attempt := 1; ; attempt++ {
+		err := puts(batch...)
+		if err == nil {
+			return nil
+		}
+		if !kv.IsRetryableError(err) || attempt >= maxAttempts {
+			return errors.Trace(err)
+		}
+		fmt.Fprintf(os.Stderr, "restore: write conflict, retrying batch (attempt %d)\n", attempt+1)
+	}
+}
+
+func doRestore(args [][]byte) error {
+	rest, opts, err := extractDumpOpts(args, 128)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(rest) != 1 {
+		return errors.New("restore [file] [--batch-size N] [--format json]")
+	}
+	if currentTx != nil {
+		return errors.New("cannot restore while a transaction is open, commit or rollback first")
+	}
+
+	f, err := os.Open(string(rest[0]))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	readNext := readDumpRecord
+	if opts.jsonFmt {
+		readNext = readJSONRecord
+	}
+
+	var batch [][]byte
+	total := 0
+	for {
+		key, val, err := readNext(r)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return errors.Trace(err)
+		}
+		if key == nil {
+			continue
+		}
+
+		batch = append(batch, key, val)
+		if len(batch) >= opts.batchSize*2 {
+			if err := restoreBatch(batch); err != nil {
+				return errors.Trace(err)
+			}
+			total += len(batch) / 2
+			fmt.Fprintf(os.Stderr, "restore: %d keys restored\n", total)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := restoreBatch(batch); err != nil {
+			return errors.Trace(err)
+		}
+		total += len(batch) / 2
+		fmt.Fprintf(os.Stderr, "restore: %d keys restored\n", total)
+	}
+	return nil
+}
+
 func do(cmd string, param [][]byte) (interface{}, error) {
 	var ret interface{}
 	var err error
@@ -160,12 +764,152 @@ func do(cmd string, param [][]byte) (interface{}, error) {
 		ret, err = doGet(param)
 	case "seek":
 		ret, err = doSeek(param)
+	case "rseek":
+		ret, err = doRSeek(param)
+	case "scan":
+		ret, err = doScan(param)
+	case "begin":
+		err = doBegin(param)
+	case "commit":
+		err = doCommit(param)
+	case "rollback":
+		err = doRollback(param)
+	case "set_isolation":
+		err = doSetIsolation(param)
+	case "dump":
+		err = doDump(param)
+	case "restore":
+		err = doRestore(param)
 	default:
-		return nil, errors.New("usage: put | puts | get | seek | del")
+		return nil, errors.New("usage: put | puts | get | seek | rseek | scan | del | begin | commit | rollback | set_isolation | dump | restore")
 	}
 	return ret, err
 }
 
+// formatKV renders a single result according to -o (plain, json, hex).
+func formatKV(kv KV) string {
+	switch *outputFormat {
+	case "json":
+		b, err := json.Marshal(kv.toJSON())
+		if err != nil {
+			return err.Error()
+		}
+		return string(b)
+	case "hex":
+		return fmt.Sprintf("%x => %x", kv.K, kv.V)
+	default:
+		return kv.String()
+	}
+}
+
+// printResult writes a command's result to stdout using the configured
+// output formatter.
+func printResult(ret interface{}) {
+	switch v := ret.(type) {
+	case KV:
+		fmt.Println(formatKV(v))
+	case []KV:
+		for _, kv := range v {
+			fmt.Println(formatKV(kv))
+		}
+	case nil:
+		if *outputFormat == "json" {
+			fmt.Println(`{"status":"ok"}`)
+		} else {
+			fmt.Println("OK")
+		}
+	}
+}
+
+// printError writes a command error using the configured output formatter,
+// so -o json scripting consumers keep getting newline-delimited JSON even
+// on failure.
+func printError(err error) {
+	if *outputFormat == "json" {
+		b, merr := json.Marshal(struct {
+			Error string `json:"error"`
+		}{Error: err.Error()})
+		if merr == nil {
+			fmt.Println(string(b))
+			return
+		}
+	}
+	fmt.Println(err)
+}
+
+// runLine parses and executes a single command line, printing its result or
+// error. It returns the command's error (nil on success), so callers running
+// in batch mode can derive a process exit status.
+func runLine(line string) error {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+
+	fields := bytes.Fields([]byte(line))
+	cmd := strings.ToLower(string(fields[0]))
+	parameters := fields[1:]
+
+	ret, err := do(cmd, parameters)
+	if err != nil {
+		printError(err)
+		return err
+	}
+	printResult(ret)
+	return nil
+}
+
+// splitStatements splits a -e argument into individual command lines.
+func splitStatements(s string) []string {
+	parts := strings.Split(s, ";")
+	stmts := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			stmts = append(stmts, p)
+		}
+	}
+	return stmts
+}
+
+// runScript executes a list of command lines in order, stopping early on
+// "exit". It returns false if any command failed, so the caller can set a
+// non-zero exit status.
+func runScript(lines []string) bool {
+	ok := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "exit" {
+			break
+		}
+		if err := runLine(line); err != nil {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// isTerminal reports whether f is attached to an interactive terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// readStdinLines reads commands from stdin, one per line, for piped/scripted
+// use (e.g. `echo 'get foo' | tikv-shell`).
+func readStdinLines() ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return lines, nil
+}
+
 func loop() {
 	l, err := readline.NewEx(&readline.Config{
 		Prompt:            "tikv> ",
@@ -180,6 +924,12 @@ func loop() {
 	defer l.Close()
 
 	for {
+		if currentTx != nil {
+			l.SetPrompt("tikv*> ")
+		} else {
+			l.SetPrompt("tikv> ")
+		}
+
 		line, err := l.Readline()
 		if err != nil {
 			if err == readline.ErrInterrupt {
@@ -193,28 +943,7 @@ func loop() {
 			os.Exit(0)
 		}
 
-		if len(line) == 0 {
-			continue
-		}
-
-		fields := bytes.Fields([]byte(line))
-		cmd := strings.ToLower(string(fields[0]))
-		parameters := fields[1:]
-
-		if ret, err := do(cmd, parameters); err != nil {
-			fmt.Println(err)
-		} else {
-			switch ret.(type) {
-			case KV:
-				fmt.Println(ret)
-			case []KV:
-				for _, kv := range ret.([]KV) {
-					fmt.Println(kv)
-				}
-			case nil:
-				fmt.Println("OK")
-			}
-		}
+		runLine(line)
 	}
 }
 
@@ -223,7 +952,24 @@ func main() {
 	if pdAddr != "" {
 		os.Args = append(os.Args, "-pd", pdAddr)
 	}
+	if ca := os.Getenv("TIKV_CA"); ca != "" {
+		os.Args = append(os.Args, "-cacert", ca)
+	}
+	if cert := os.Getenv("TIKV_CERT"); cert != "" {
+		os.Args = append(os.Args, "-cert", cert)
+	}
+	if key := os.Getenv("TIKV_KEY"); key != "" {
+		os.Args = append(os.Args, "-key", key)
+	}
 	flag.Parse()
+
+	switch *outputFormat {
+	case "plain", "json", "hex":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown output format %q, want plain, json or hex\n", *outputFormat)
+		os.Exit(1)
+	}
+
 	initStore()
 
 	sc := make(chan os.Signal, 1)
@@ -243,5 +989,25 @@ func main() {
 			os.Exit(1)
 		}
 	}()
+
+	if *execStr != "" {
+		if !runScript(splitStatements(*execStr)) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if !isTerminal(os.Stdin) {
+		lines, err := readStdinLines()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if !runScript(lines) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	loop()
 }